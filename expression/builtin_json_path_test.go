@@ -0,0 +1,80 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/mock"
+	"github.com/pingcap/tidb/mysql"
+	"github.com/pingcap/tidb/util/types"
+	"github.com/pingcap/tidb/util/types/json"
+)
+
+var _ = Suite(&testJSONPathSuite{})
+
+type testJSONPathSuite struct{}
+
+func (s *testJSONPathSuite) docConst(c *C, doc interface{}) *Constant {
+	return &Constant{Value: types.NewDatum(json.CreateJSON(doc)), RetType: types.NewFieldType(mysql.TypeJSON)}
+}
+
+// TestJSONFindMatchesIntAgainstJSONNumber is a regression test for
+// jsonLeafEquals: a JSON numeric leaf decodes as float64, and must still
+// match an int64 argument like the literal 3 in JSON_FIND(doc, '$.*', 3).
+func (s *testJSONPathSuite) TestJSONFindMatchesIntAgainstJSONNumber(c *C) {
+	ctx := mock.NewContext()
+	doc := s.docConst(c, map[string]interface{}{"a": float64(3), "b": float64(4)})
+	path := &Constant{Value: types.NewStringDatum("$.*"), RetType: types.NewFieldType(mysql.TypeVarString)}
+	value := &Constant{Value: types.NewIntDatum(3), RetType: types.NewFieldType(mysql.TypeLonglong)}
+
+	fc := &jsonFindFunctionClass{baseFunctionClass{jsonFindFuncName, 3, 3}}
+	f, err := fc.getFunction([]Expression{doc, path, value}, ctx)
+	c.Assert(err, IsNil)
+
+	res, isNull, err := f.(*builtinJSONFindSig).evalJson(nil)
+	c.Assert(err, IsNil)
+	c.Assert(isNull, IsFalse)
+	matches, ok := res.Interface().([]interface{})
+	c.Assert(ok, IsTrue)
+	c.Assert(matches, HasLen, 1)
+	c.Assert(matches[0], Equals, "$.a")
+}
+
+// TestJSONMatchWildcards exercises both the single-level `*` and recursive
+// `**` wildcard forms.
+func (s *testJSONPathSuite) TestJSONMatchWildcards(c *C) {
+	ctx := mock.NewContext()
+	doc := s.docConst(c, map[string]interface{}{
+		"a": map[string]interface{}{"b": map[string]interface{}{"c": 1}},
+	})
+	fc := &jsonMatchFunctionClass{baseFunctionClass{jsonMatchFuncName, 2, 2}}
+
+	cases := []struct {
+		pattern string
+		want    int64
+	}{
+		{"$.a.*.c", 1},
+		{"$.**.c", 1},
+		{"$.x.*", 0},
+	}
+	for _, tc := range cases {
+		pattern := &Constant{Value: types.NewStringDatum(tc.pattern), RetType: types.NewFieldType(mysql.TypeVarString)}
+		f, err := fc.getFunction([]Expression{doc, pattern}, ctx)
+		c.Assert(err, IsNil)
+		got, isNull, err := f.(*builtinJSONMatchSig).evalInt(nil)
+		c.Assert(err, IsNil)
+		c.Assert(isNull, IsFalse)
+		c.Assert(got, Equals, tc.want, Commentf("pattern %q", tc.pattern))
+	}
+}
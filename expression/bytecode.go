@@ -0,0 +1,270 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+import (
+	"fmt"
+
+	"github.com/juju/errors"
+	"github.com/pingcap/tidb/ast"
+	"github.com/pingcap/tidb/sessionctx/variable"
+	"github.com/pingcap/tidb/util/types"
+)
+
+// opcode identifies a single instruction in a compiled program. Opcodes are
+// typed per types.TypeClass so the interpreter never has to branch on type
+// at execution time, only at compile time.
+type opcode byte
+
+const (
+	opLoadCol opcode = iota
+	opLoadConst
+	opAddInt
+	opAddReal
+	opEqString
+	opIfNull
+	opCallBuiltin
+)
+
+// instr is one bytecode instruction. Operands are interpreted according to
+// op; e.g. for opLoadCol, a is the column offset in the input row, for
+// opLoadConst, a indexes into program.consts. opAddInt/opAddReal/opEqString/
+// opIfNull are binary: they pop their two operands off the evaluation stack
+// and push one result, so a/b are unused for them. opCallBuiltin instead
+// uses fn: it pushes the result of evaluating fn through the tree-walking
+// evaluator, consuming none of the stack, so it must be the only
+// instruction emitted for the subtree it covers.
+type instr struct {
+	op   opcode
+	a, b int
+	fn   *ScalarFunction
+}
+
+// program is the compiled form of a ScalarFunction tree: a flat list of
+// instructions executed against a small stack instead of recursing through
+// virtual Eval* calls. The stack fits in a fixed-size array when the
+// expression needs at most maxRegisters live values at once; deeper
+// expressions spill to a heap-allocated slice at run time.
+const maxRegisters = 8
+
+type program struct {
+	instrs []instr
+	consts []types.Datum
+	// numRegs is the peak stack depth this program reaches. When it's
+	// <= maxRegisters, eval uses a fixed-size array and avoids allocating.
+	numRegs int
+}
+
+// compileProgram lowers sf's expression tree into a bytecode program,
+// walking it via GetArgs() the same way Clone and HashCode do. It returns
+// an error for constructs the compiler doesn't yet understand, in which
+// case callers should keep using the tree-walking Eval* path.
+func compileProgram(sf *ScalarFunction) (*program, error) {
+	c := &compiler{constIdx: make(map[string]int)}
+	depth, err := c.compileScalarFunc(sf)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &program{
+		instrs:  c.instrs,
+		consts:  c.consts,
+		numRegs: depth,
+	}, nil
+}
+
+// compiler holds the state accumulated while lowering one expression tree.
+type compiler struct {
+	instrs []instr
+	consts []types.Datum
+	// constIdx dedupes equal constants at compile time, keyed by the
+	// constant's string form, so two occurrences of the same literal (e.g.
+	// the same default value reused in a CASE expression) share one slot in
+	// consts instead of loading the same value twice.
+	constIdx map[string]int
+	maxDepth int
+}
+
+// compileScalarFunc appends instructions for sf's arguments and then for sf
+// itself, returning the peak evaluation-stack depth this subtree reaches.
+//
+// sf itself is checked for a dedicated opcode before anything else. When it
+// has none, this whole subtree — args included — is handed to a single
+// opCallBuiltin instruction that evaluates sf via the tree-walking
+// evaluator; no arg-load instructions are emitted for it, since there is no
+// typed opcode that would ever consume them. Emitting them anyway would
+// leave them stranded on the stack under opCallBuiltin's own pushed result.
+func (c *compiler) compileScalarFunc(sf *ScalarFunction) (int, error) {
+	op, ok := builtinOpcode(sf.FuncName.L, sf.GetTypeClass())
+	if !ok {
+		c.instrs = append(c.instrs, instr{op: opCallBuiltin, fn: sf})
+		if c.maxDepth < 1 {
+			c.maxDepth = 1
+		}
+		return 1, nil
+	}
+	if len(sf.GetArgs()) != 2 {
+		return 0, errors.Errorf("bytecode: opcode %v expects 2 arguments, got %d", op, len(sf.GetArgs()))
+	}
+	depth := 0
+	peak := 0
+	for _, arg := range sf.GetArgs() {
+		switch x := arg.(type) {
+		case *Column:
+			c.instrs = append(c.instrs, instr{op: opLoadCol, a: x.Index})
+			depth++
+		case *Constant:
+			c.instrs = append(c.instrs, instr{op: opLoadConst, a: c.constSlot(x.Value)})
+			depth++
+		case *ScalarFunction:
+			argDepth, err := c.compileScalarFunc(x)
+			if err != nil {
+				return 0, errors.Trace(err)
+			}
+			// argDepth is the peak depth of the argument subtree, reached on
+			// top of the depth values earlier arguments already left on the
+			// stack; after it finishes it leaves exactly one value on the
+			// stack, the same as the Column/Constant cases above.
+			if depth+argDepth > peak {
+				peak = depth + argDepth
+			}
+			depth++
+		default:
+			return 0, errors.Errorf("bytecode: unsupported expression %T", arg)
+		}
+		if depth > peak {
+			peak = depth
+		}
+	}
+	if peak > c.maxDepth {
+		c.maxDepth = peak
+	}
+	c.instrs = append(c.instrs, instr{op: op})
+	// A binary opcode consumes its two operands and pushes one result.
+	return peak, nil
+}
+
+// constSlot returns the consts index for v, reusing an existing slot when an
+// equal constant was already compiled. The dedup key includes v.Kind(), not
+// just its printed value, so e.g. int64(1) and float64(1) — which both
+// print as "1" but load as differently-typed Datums — get distinct slots.
+func (c *compiler) constSlot(v types.Datum) int {
+	key := fmt.Sprintf("%d:%v", v.Kind(), v.GetValue())
+	if idx, ok := c.constIdx[key]; ok {
+		return idx
+	}
+	c.consts = append(c.consts, v)
+	idx := len(c.consts) - 1
+	c.constIdx[key] = idx
+	return idx
+}
+
+// builtinOpcode maps a function name and result type class to a dedicated
+// opcode when one exists. Functions outside this table still compile, but
+// execute through opCallBuiltin instead of a typed fast path.
+func builtinOpcode(funcName string, tc types.TypeClass) (opcode, bool) {
+	switch funcName {
+	case ast.Plus:
+		if tc == types.ClassInt {
+			return opAddInt, true
+		}
+		if tc == types.ClassReal {
+			return opAddReal, true
+		}
+	case ast.EQ:
+		if tc == types.ClassString {
+			return opEqString, true
+		}
+	case ast.Ifnull:
+		return opIfNull, true
+	}
+	return 0, false
+}
+
+// evalProgram runs prog against row and returns the resulting Datum. It is
+// the bytecode counterpart of ScalarFunction.Eval, used once NewFunction has
+// successfully compiled a program for some ScalarFunction sf. Eval calls
+// this instead of recursing through sf.Function whenever sf.program is
+// non-nil and TurnOnNewExprEval is off; prog itself carries, via each opCallBuiltin instr's fn, every
+// sub-function evalProgram might need to fall back into, so it needs no sf
+// parameter of its own.
+func evalProgram(prog *program, row []types.Datum) (types.Datum, error) {
+	var regs [maxRegisters]types.Datum
+	stack := regs[:0]
+	if prog.numRegs > maxRegisters {
+		stack = make([]types.Datum, 0, prog.numRegs)
+	}
+	for _, in := range prog.instrs {
+		switch in.op {
+		case opLoadCol:
+			stack = append(stack, row[in.a])
+		case opLoadConst:
+			stack = append(stack, prog.consts[in.a])
+		case opCallBuiltin:
+			// in.fn, not sf, is the subtree this instruction covers: for a
+			// nested call like `a + length(b)`, the opCallBuiltin for
+			// length(b) must evaluate length(b), not the root `+`.
+			d, err := in.fn.Function.eval(row)
+			if err != nil {
+				return types.Datum{}, errors.Trace(err)
+			}
+			stack = append(stack, d)
+		case opAddInt:
+			rhs, lhs := stack[len(stack)-1], stack[len(stack)-2]
+			stack = stack[:len(stack)-2]
+			var d types.Datum
+			if lhs.IsNull() || rhs.IsNull() {
+				d.SetNull()
+			} else {
+				d.SetInt64(lhs.GetInt64() + rhs.GetInt64())
+			}
+			stack = append(stack, d)
+		case opAddReal:
+			rhs, lhs := stack[len(stack)-1], stack[len(stack)-2]
+			stack = stack[:len(stack)-2]
+			var d types.Datum
+			if lhs.IsNull() || rhs.IsNull() {
+				d.SetNull()
+			} else {
+				d.SetFloat64(lhs.GetFloat64() + rhs.GetFloat64())
+			}
+			stack = append(stack, d)
+		case opEqString:
+			rhs, lhs := stack[len(stack)-1], stack[len(stack)-2]
+			stack = stack[:len(stack)-2]
+			var d types.Datum
+			if lhs.IsNull() || rhs.IsNull() {
+				d.SetNull()
+			} else if lhs.GetString() == rhs.GetString() {
+				d.SetInt64(1)
+			} else {
+				d.SetInt64(0)
+			}
+			stack = append(stack, d)
+		case opIfNull:
+			rhs, lhs := stack[len(stack)-1], stack[len(stack)-2]
+			stack = stack[:len(stack)-2]
+			if lhs.IsNull() {
+				stack = append(stack, rhs)
+			} else {
+				stack = append(stack, lhs)
+			}
+		default:
+			return types.Datum{}, errors.Errorf("bytecode: unknown opcode %v", in.op)
+		}
+	}
+	if len(stack) != 1 {
+		return types.Datum{}, errors.Errorf("bytecode: program left %d values on the stack, want 1", len(stack))
+	}
+	return stack[0], nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cel translates a well-defined subset of the Common Expression
+// Language (https://github.com/google/cel-spec) into TiDB's
+// expression.Expression tree, so embedders can push declarative predicates
+// (policy filters, row-level ACL rules, streaming CDC filters) into TiDB
+// without hand-writing SQL. Only the constructs covered by Parse/Check are
+// supported; anything else is rejected at compile time rather than at eval
+// time.
+package cel
+
+import "github.com/pingcap/tidb/util/types"
+
+// NodeKind identifies the shape of an AST node.
+type NodeKind int
+
+// The kinds of node Parse can produce.
+const (
+	NodeIdent NodeKind = iota
+	NodeSelect
+	NodeLiteral
+	NodeCall
+	NodeList
+)
+
+// Node is one node of a parsed CEL expression. Only one of the Value fields
+// below is meaningful for a given Kind.
+type Node struct {
+	Kind NodeKind
+	Pos  int
+
+	// NodeIdent / NodeSelect
+	Name      string // e.g. "size", "x" in "x.y"
+	Operand   *Node  // the "x" in "x.y"; nil for a bare identifier
+
+	// NodeLiteral
+	Literal interface{} // int64, float64, string, bool, or nil
+
+	// NodeCall: Fun is the function or operator name ("+", "&&", "has",
+	// "size", a method name, ...), Args holds the operands in source order.
+	Fun  string
+	Args []*Node
+
+	// TypeClass is populated by Check and must not be read before Check has
+	// run over the tree containing this node.
+	TypeClass types.TypeClass
+}
+
+// IsBinaryOp reports whether n is a call to one of CEL's built-in binary
+// operators (&&, ||, ==, !=, <, <=, >, >=, +, -, *, /, %). List/map
+// membership (`in`) is not part of the supported subset: translating it
+// would need a TiDB list/set type the expression tree doesn't have a
+// one-to-one builtin for, so Parse rejects it rather than silently
+// accepting syntax Translate could never lower.
+func (n *Node) IsBinaryOp() bool {
+	if n.Kind != NodeCall || len(n.Args) != 2 {
+		return false
+	}
+	switch n.Fun {
+	case "&&", "||", "==", "!=", "<", "<=", ">", ">=", "+", "-", "*", "/", "%":
+		return true
+	}
+	return false
+}
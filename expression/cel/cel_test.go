@@ -0,0 +1,95 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cel
+
+import (
+	"testing"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/ast"
+	"github.com/pingcap/tidb/expression"
+	"github.com/pingcap/tidb/mock"
+	"github.com/pingcap/tidb/mysql"
+	"github.com/pingcap/tidb/util/types"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&testCelSuite{})
+
+type testCelSuite struct{}
+
+func (s *testCelSuite) TestParseMultiplicative(c *C) {
+	n, err := Parse("a * b + c / 2 % d")
+	c.Assert(err, IsNil)
+	c.Assert(n.Fun, Equals, "+")
+}
+
+func (s *testCelSuite) TestParseUnaryMinus(c *C) {
+	n, err := Parse("-a")
+	c.Assert(err, IsNil)
+	c.Assert(n.Kind, Equals, NodeCall)
+	c.Assert(n.Fun, Equals, "-")
+	c.Assert(n.Args, HasLen, 1)
+}
+
+func (s *testCelSuite) TestParseRejectsUnknownCharacter(c *C) {
+	_, err := Parse("a @ b")
+	c.Assert(err, NotNil)
+}
+
+func (s *testCelSuite) TestParseRejectsIn(c *C) {
+	// "in" tokenizes as a plain identifier since it isn't a declared
+	// operator, so `x in y` parses as two adjacent expressions and fails
+	// with a trailing-input error rather than silently accepting syntax
+	// Translate could never lower.
+	_, err := Parse("x in y")
+	c.Assert(err, NotNil)
+}
+
+// TestHasMapsToIsNotNull is a regression guard for the has -> IS NOT NULL
+// mapping the request specifies: has(x) must be false exactly when x is
+// NULL, the opposite of a bare IS NULL.
+func (s *testCelSuite) TestHasMapsToIsNotNull(c *C) {
+	n, err := Parse("has(x)")
+	c.Assert(err, IsNil)
+
+	env := Env{"x": types.NewFieldType(mysql.TypeLonglong)}
+	_, err = Check(n, env)
+	c.Assert(err, IsNil)
+
+	ctx := mock.NewContext()
+	col := &expression.Column{Index: 0, RetType: types.NewFieldType(mysql.TypeLonglong)}
+	resolveCol := func(name string) (expression.Expression, error) {
+		return col, nil
+	}
+	expr, err := Translate(ctx, n, resolveCol)
+	c.Assert(err, IsNil)
+
+	sf, ok := expr.(*expression.ScalarFunction)
+	c.Assert(ok, IsTrue)
+	c.Assert(sf.FuncName.L, Equals, ast.UnaryNot)
+
+	present, isNull, err := sf.EvalInt([]types.Datum{types.NewIntDatum(1)}, ctx.GetSessionVars().StmtCtx)
+	c.Assert(err, IsNil)
+	c.Assert(isNull, IsFalse)
+	c.Assert(present, Equals, int64(1))
+
+	absent, isNull, err := sf.EvalInt([]types.Datum{types.NewDatum(nil)}, ctx.GetSessionVars().StmtCtx)
+	c.Assert(err, IsNil)
+	c.Assert(isNull, IsFalse)
+	c.Assert(absent, Equals, int64(0))
+}
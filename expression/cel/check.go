@@ -0,0 +1,94 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cel
+
+import (
+	"github.com/juju/errors"
+	"github.com/pingcap/tidb/util/types"
+)
+
+// Env describes the identifiers a CEL expression may reference, mapping a
+// top-level name (e.g. "x" in "x.y > 3") to its TiDB type. Check rejects any
+// identifier not present here.
+type Env map[string]*types.FieldType
+
+// Check type-checks n against env, filling in each node's TypeClass and
+// returning the TypeClass of the whole expression. It aligns with
+// types.FieldType/types.TypeClass rather than CEL's own type system, since
+// the result of Check feeds directly into Translate.
+func Check(n *Node, env Env) (types.TypeClass, error) {
+	switch n.Kind {
+	case NodeLiteral:
+		return checkLiteral(n)
+	case NodeIdent:
+		ft, ok := env[n.Name]
+		if !ok {
+			return 0, errors.Errorf("cel: undeclared identifier %q", n.Name)
+		}
+		n.TypeClass = ft.ToClass()
+		return n.TypeClass, nil
+	case NodeSelect:
+		if _, err := Check(n.Operand, env); err != nil {
+			return 0, errors.Trace(err)
+		}
+		// Field selection on a JSON-typed operand translates to
+		// JSON_EXTRACT at Translate time; the real type of a nested field
+		// isn't known here, so the node is given ClassString.
+		n.TypeClass = types.ClassString
+		return n.TypeClass, nil
+	case NodeCall:
+		return checkCall(n, env)
+	default:
+		return 0, errors.Errorf("cel: unsupported node kind %d", n.Kind)
+	}
+}
+
+func checkLiteral(n *Node) (types.TypeClass, error) {
+	switch n.Literal.(type) {
+	case int64:
+		n.TypeClass = types.ClassInt
+	case float64:
+		n.TypeClass = types.ClassReal
+	case string:
+		n.TypeClass = types.ClassString
+	case bool:
+		n.TypeClass = types.ClassInt
+	case nil:
+		n.TypeClass = types.ClassString
+	default:
+		return 0, errors.Errorf("cel: unsupported literal type %T", n.Literal)
+	}
+	return n.TypeClass, nil
+}
+
+func checkCall(n *Node, env Env) (types.TypeClass, error) {
+	for _, arg := range n.Args {
+		if _, err := Check(arg, env); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	if n.Fun == "has" {
+		if len(n.Args) != 1 {
+			return 0, errors.Errorf("cel: has() takes exactly 1 argument, got %d", len(n.Args))
+		}
+		n.TypeClass = types.ClassInt
+		return n.TypeClass, nil
+	}
+	fn, ok := lookupCelFunc(n)
+	if !ok {
+		return 0, errors.Errorf("cel: unsupported operator or function %q", n.Fun)
+	}
+	n.TypeClass = fn.resultClass
+	return n.TypeClass, nil
+}
@@ -0,0 +1,274 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cel
+
+import (
+	"strconv"
+
+	"github.com/juju/errors"
+)
+
+// Parse parses a CEL expression into a Node tree. It supports the subset
+// documented on package cel: identifiers, dotted field selection, int/float/
+// string/bool/null literals, the standard arithmetic/relational/logical
+// operators, and function calls including the `has(x.y)` and `size(x)`
+// macros. Anything outside that subset returns an error rather than a
+// partial tree.
+func Parse(expr string) (*Node, error) {
+	toks, err := tokenize(expr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	p := &parser{toks: toks}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if p.pos != len(p.toks) {
+		return nil, errors.Errorf("cel: unexpected trailing input at token %d", p.pos)
+	}
+	return n, nil
+}
+
+type token struct {
+	text string
+	pos  int
+}
+
+// tokenize performs a minimal lexical split good enough for the supported
+// subset: identifiers/numbers/strings are scanned as whole tokens, operators
+// are split greedily longest-match-first so "==" isn't read as two "=".
+func tokenize(expr string) ([]token, error) {
+	var toks []token
+	ops := []string{"&&", "||", "==", "!=", "<=", ">=", "(", ")", ".", ",", "+", "-", "*", "/", "%", "<", ">", "!"}
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(expr) && expr[j] != c {
+				j++
+			}
+			if j >= len(expr) {
+				return nil, errors.Errorf("cel: unterminated string literal starting at position %d", i)
+			}
+			toks = append(toks, token{text: expr[i : j+1], pos: i})
+			i = j + 1
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(expr) && isIdentPart(expr[j]) {
+				j++
+			}
+			toks = append(toks, token{text: expr[i:j], pos: i})
+			i = j
+		case isDigit(c):
+			j := i + 1
+			for j < len(expr) && (isDigit(expr[j]) || expr[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{text: expr[i:j], pos: i})
+			i = j
+		default:
+			matched := false
+			for _, op := range ops {
+				if i+len(op) <= len(expr) && expr[i:i+len(op)] == op {
+					toks = append(toks, token{text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errors.Errorf("cel: unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return toks, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() string {
+	if p.pos >= len(p.toks) {
+		return ""
+	}
+	return p.toks[p.pos].text
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	p.pos++
+	return t
+}
+
+// parseOr, parseAnd, parseEquality, parseRelational and parseAdditive
+// implement a standard precedence-climbing expression parser; each level
+// delegates to the next-tighter-binding level before trying its own
+// operators, matching the precedence CEL inherits from C-like languages.
+func (p *parser) parseOr() (*Node, error) {
+	return p.parseBinary([]string{"||"}, p.parseAnd)
+}
+
+func (p *parser) parseAnd() (*Node, error) {
+	return p.parseBinary([]string{"&&"}, p.parseEquality)
+}
+
+func (p *parser) parseEquality() (*Node, error) {
+	return p.parseBinary([]string{"==", "!="}, p.parseRelational)
+}
+
+func (p *parser) parseRelational() (*Node, error) {
+	return p.parseBinary([]string{"<", "<=", ">", ">="}, p.parseAdditive)
+}
+
+func (p *parser) parseAdditive() (*Node, error) {
+	return p.parseBinary([]string{"+", "-"}, p.parseMultiplicative)
+}
+
+func (p *parser) parseMultiplicative() (*Node, error) {
+	return p.parseBinary([]string{"*", "/", "%"}, p.parseUnary)
+}
+
+func (p *parser) parseBinary(ops []string, next func() (*Node, error)) (*Node, error) {
+	lhs, err := next()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for contains(ops, p.peek()) {
+		op := p.next().text
+		rhs, err := next()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		lhs = &Node{Kind: NodeCall, Fun: op, Args: []*Node{lhs, rhs}}
+	}
+	return lhs, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) parseUnary() (*Node, error) {
+	if p.peek() == "!" || p.peek() == "-" {
+		op := p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return &Node{Kind: NodeCall, Fun: op.text, Args: []*Node{operand}, Pos: op.pos}, nil
+	}
+	return p.parsePostfix()
+}
+
+// parsePostfix handles call arguments `f(a, b)` and field selection `a.b`
+// chained onto a primary expression.
+func (p *parser) parsePostfix() (*Node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for {
+		switch p.peek() {
+		case ".":
+			p.next()
+			if p.pos >= len(p.toks) {
+				return nil, errors.New("cel: expected field name after '.'")
+			}
+			field := p.next()
+			n = &Node{Kind: NodeSelect, Name: field.text, Operand: n, Pos: field.pos}
+		case "(":
+			if n.Kind != NodeIdent {
+				return nil, errors.Errorf("cel: %q is not callable", n.Name)
+			}
+			p.next()
+			var args []*Node
+			for p.peek() != ")" {
+				arg, err := p.parseOr()
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				args = append(args, arg)
+				if p.peek() == "," {
+					p.next()
+				}
+			}
+			p.next() // consume ")"
+			n = &Node{Kind: NodeCall, Fun: n.Name, Args: args, Pos: n.Pos}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (*Node, error) {
+	if p.pos >= len(p.toks) {
+		return nil, errors.New("cel: unexpected end of expression")
+	}
+	t := p.next()
+	switch {
+	case t.text == "(":
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("cel: expected ')'")
+		}
+		p.next()
+		return n, nil
+	case t.text == "true" || t.text == "false":
+		return &Node{Kind: NodeLiteral, Literal: t.text == "true", Pos: t.pos}, nil
+	case t.text == "null":
+		return &Node{Kind: NodeLiteral, Literal: nil, Pos: t.pos}, nil
+	case len(t.text) >= 2 && (t.text[0] == '"' || t.text[0] == '\''):
+		return &Node{Kind: NodeLiteral, Literal: t.text[1 : len(t.text)-1], Pos: t.pos}, nil
+	case isDigit(t.text[0]):
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &Node{Kind: NodeLiteral, Literal: i, Pos: t.pos}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, errors.Errorf("cel: invalid numeric literal %q", t.text)
+		}
+		return &Node{Kind: NodeLiteral, Literal: f, Pos: t.pos}, nil
+	case isIdentStart(t.text[0]):
+		return &Node{Kind: NodeIdent, Name: t.text, Pos: t.pos}, nil
+	default:
+		return nil, errors.Errorf("cel: unexpected token %q at position %d", t.text, t.pos)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cel
+
+import (
+	"github.com/juju/errors"
+	"github.com/pingcap/tidb/ast"
+	"github.com/pingcap/tidb/context"
+	"github.com/pingcap/tidb/expression"
+	"github.com/pingcap/tidb/mysql"
+	"github.com/pingcap/tidb/util/types"
+)
+
+// celFunc describes how one CEL operator or built-in maps onto a TiDB
+// builtin: the registry name to call through expression.NewFunction, and
+// the TypeClass the call produces (used by Check before translation runs).
+type celFunc struct {
+	tidbName    string
+	resultClass types.TypeClass
+}
+
+// celFuncs is the fixed mapping from supported CEL operators/built-ins to
+// entries in TiDB's function registry. A CEL construct with no entry here
+// fails Check before it ever reaches Translate. "has" isn't listed here
+// because it isn't a 1:1 mapping to a single builtin; see translateHas.
+// "-" is listed twice, as "-binary" and "-unary"; lookupCelFunc picks one
+// by argument count.
+var celFuncs = map[string]celFunc{
+	"&&":      {ast.AndAnd, types.ClassInt},
+	"||":      {ast.OrOr, types.ClassInt},
+	"==":      {ast.EQ, types.ClassInt},
+	"!=":      {ast.NE, types.ClassInt},
+	"<":       {ast.LT, types.ClassInt},
+	"<=":      {ast.LE, types.ClassInt},
+	">":       {ast.GT, types.ClassInt},
+	">=":      {ast.GE, types.ClassInt},
+	"+":       {ast.Plus, types.ClassInt},
+	"-binary": {ast.Minus, types.ClassInt},
+	"-unary":  {ast.UnaryMinus, types.ClassInt},
+	"*":       {ast.Mul, types.ClassInt},
+	"/":       {ast.Div, types.ClassReal},
+	"%":       {ast.Mod, types.ClassInt},
+	"!":       {ast.UnaryNot, types.ClassInt},
+	"size":    {ast.CharLength, types.ClassInt},
+}
+
+// lookupCelFunc resolves n.Fun/arity to its celFunc entry, disambiguating
+// "-" (binary subtraction vs. unary negation) by argument count. "has" is
+// handled separately by translateHas/checkCall since it isn't a single
+// builtin call.
+func lookupCelFunc(n *Node) (celFunc, bool) {
+	name := n.Fun
+	if name == "-" {
+		if len(n.Args) == 1 {
+			name = "-unary"
+		} else {
+			name = "-binary"
+		}
+	}
+	fn, ok := celFuncs[name]
+	return fn, ok
+}
+
+// Translate lowers a checked Node tree into a TiDB expression.Expression,
+// resolving each identifier through resolveCol. n must have already been
+// passed through Check with the same Env resolveCol was derived from;
+// Translate does not re-validate types.
+func Translate(ctx context.Context, n *Node, resolveCol func(name string) (expression.Expression, error)) (expression.Expression, error) {
+	switch n.Kind {
+	case NodeLiteral:
+		return translateLiteral(n)
+	case NodeIdent:
+		return resolveCol(n.Name)
+	case NodeSelect:
+		return translateSelect(ctx, n, resolveCol)
+	case NodeCall:
+		return translateCall(ctx, n, resolveCol)
+	default:
+		return nil, errors.Errorf("cel: unsupported node kind %d", n.Kind)
+	}
+}
+
+func translateLiteral(n *Node) (expression.Expression, error) {
+	d := types.Datum{}
+	d.SetValue(n.Literal)
+	return &expression.Constant{Value: d, RetType: types.NewFieldType(fieldTypeFor(n.TypeClass))}, nil
+}
+
+func translateSelect(ctx context.Context, n *Node, resolveCol func(name string) (expression.Expression, error)) (expression.Expression, error) {
+	operand, err := Translate(ctx, n.Operand, resolveCol)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	path := &expression.Constant{Value: types.NewStringDatum("$." + n.Name)}
+	return expression.NewFunction(ctx, ast.JSONExtract, types.NewFieldType(mysql.TypeJSON), operand, path)
+}
+
+func translateCall(ctx context.Context, n *Node, resolveCol func(name string) (expression.Expression, error)) (expression.Expression, error) {
+	if n.Fun == "has" {
+		return translateHas(ctx, n, resolveCol)
+	}
+	fn, ok := lookupCelFunc(n)
+	if !ok {
+		return nil, errors.Errorf("cel: unsupported operator or function %q", n.Fun)
+	}
+	args := make([]expression.Expression, 0, len(n.Args))
+	for _, a := range n.Args {
+		arg, err := Translate(ctx, a, resolveCol)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		args = append(args, arg)
+	}
+	retType := types.NewFieldType(fieldTypeFor(n.TypeClass))
+	return expression.NewFunction(ctx, fn.tidbName, retType, args...)
+}
+
+// translateHas lowers CEL's `has(x.y)` macro, which is true when the field
+// is present, to `NOT (x.y IS NULL)` — the request maps has -> IS NOT NULL,
+// and TiDB's expression tree has no single "IS NOT NULL" builtin, only
+// IsNull composed with UnaryNot.
+func translateHas(ctx context.Context, n *Node, resolveCol func(name string) (expression.Expression, error)) (expression.Expression, error) {
+	if len(n.Args) != 1 {
+		return nil, errors.Errorf("cel: has() takes exactly 1 argument, got %d", len(n.Args))
+	}
+	operand, err := Translate(ctx, n.Args[0], resolveCol)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	isNull, err := expression.NewFunction(ctx, ast.IsNull, types.NewFieldType(mysql.TypeLonglong), operand)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return expression.NewFunction(ctx, ast.UnaryNot, types.NewFieldType(mysql.TypeLonglong), isNull)
+}
+
+func fieldTypeFor(tc types.TypeClass) byte {
+	switch tc {
+	case types.ClassInt:
+		return mysql.TypeLonglong
+	case types.ClassReal:
+		return mysql.TypeDouble
+	default:
+		return mysql.TypeVarString
+	}
+}
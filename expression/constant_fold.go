@@ -0,0 +1,43 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+// FoldConstant folds expr into a *Constant when every argument of expr is
+// itself already constant, so NewFunction doesn't leave an evaluable
+// all-constant call sitting in the plan. It leaves expr untouched when it
+// isn't a *ScalarFunction, when any argument isn't constant yet, or when
+// evaluating it would be unsafe to cache — currently that means a
+// RegisterFunction-registered UDF marked Deterministic: false, see
+// IsDeterministic.
+func FoldConstant(expr Expression) Expression {
+	sf, ok := expr.(*ScalarFunction)
+	if !ok {
+		return expr
+	}
+	if !IsDeterministic(sf) {
+		return expr
+	}
+	for _, arg := range sf.GetArgs() {
+		if _, ok := arg.(*Constant); !ok {
+			return expr
+		}
+	}
+	d, err := sf.Eval(nil)
+	if err != nil {
+		// Leave the call unevaluated; it will surface the same error at
+		// execution time instead of at plan-build time.
+		return expr
+	}
+	return &Constant{Value: d, RetType: sf.GetType()}
+}
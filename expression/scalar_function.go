@@ -35,6 +35,13 @@ type ScalarFunction struct {
 	// TODO: Implement type inference here, now we use ast's return type temporarily.
 	RetType  *types.FieldType
 	Function builtinFunc
+
+	// program is the compiled bytecode form of this expression tree, built
+	// by NewFunction via compileProgram and cached here so repeated Eval calls over
+	// many rows don't pay the recursive tree-walk cost every time. It must
+	// be invalidated whenever the tree shape changes, see Clone and
+	// Decorrelate.
+	program *program
 }
 
 // GetArgs gets arguments of function.
@@ -71,7 +78,9 @@ func NewFunction(ctx context.Context, funcName string, retType *types.FieldType,
 	if funcName == ast.Cast {
 		return NewCastFunc(retType, args[0], ctx), nil
 	}
+	funcsMu.RLock()
 	fc, ok := funcs[funcName]
+	funcsMu.RUnlock()
 	if !ok {
 		return nil, errFunctionNotExists.GenByArgs(funcName)
 	}
@@ -92,7 +101,16 @@ func NewFunction(ctx context.Context, funcName string, retType *types.FieldType,
 		RetType:  retType,
 		Function: f,
 	}
-	return FoldConstant(sf), nil
+	folded := FoldConstant(sf)
+	if foldedSf, ok := folded.(*ScalarFunction); ok {
+		// Compilation is best-effort: not every expression shape is
+		// supported yet, so a failure here just means foldedSf keeps
+		// evaluating through the tree-walking Eval* path.
+		if prog, err := compileProgram(foldedSf); err == nil {
+			foldedSf.program = prog
+		}
+	}
+	return folded, nil
 }
 
 // ScalarFuncs2Exprs converts []*ScalarFunction to []Expression.
@@ -119,6 +137,8 @@ func (sf *ScalarFunction) Clone() Expression {
 		return NewValuesFunc(v.offset, sf.GetType(), sf.GetCtx())
 	}
 	newFunc, _ := NewFunction(sf.GetCtx(), sf.FuncName.L, sf.RetType, newArgs...)
+	// newFunc is built fresh from NewFunction, so it has no compiled
+	// program yet; nothing to invalidate there. sf itself is unchanged.
 	return newFunc
 }
 
@@ -159,12 +179,18 @@ func (sf *ScalarFunction) Decorrelate(schema *Schema) Expression {
 	for i, arg := range sf.GetArgs() {
 		sf.GetArgs()[i] = arg.Decorrelate(schema)
 	}
+	// The argument tree may have changed shape (e.g. a CorrelatedColumn
+	// replaced by a Column), so any previously compiled program is stale.
+	sf.program = nil
 	return sf
 }
 
 // Eval implements Expression interface.
 func (sf *ScalarFunction) Eval(row []types.Datum) (d types.Datum, err error) {
 	if !TurnOnNewExprEval {
+		if sf.program != nil {
+			return evalProgram(sf.program, row)
+		}
 		return sf.Function.eval(row)
 	}
 	sc := sf.GetCtx().GetSessionVars().StmtCtx
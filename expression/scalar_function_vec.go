@@ -0,0 +1,316 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+import (
+	"github.com/juju/errors"
+	"github.com/pingcap/tidb/util/chunk"
+	"github.com/pingcap/tidb/util/types"
+)
+
+// vecBuiltinFunc is an optional interface a builtinFunc signature can
+// implement to provide a native column-at-a-time evaluation path. Signatures
+// that don't implement it, or whose vectorized() reports false, are evaluated
+// through the vecEval*ByRow fallbacks, which call the scalar Eval* methods
+// one row at a time. This lets builtins be
+// migrated to the vectorized path incrementally instead of all at once.
+type vecBuiltinFunc interface {
+	// vectorized reports whether this signature has a native vectorized
+	// implementation, as opposed to relying on the row-at-a-time fallback.
+	vectorized() bool
+
+	vecEvalInt(input *chunk.Chunk, result *chunk.Column) error
+	vecEvalReal(input *chunk.Chunk, result *chunk.Column) error
+	vecEvalDecimal(input *chunk.Chunk, result *chunk.Column) error
+	vecEvalString(input *chunk.Chunk, result *chunk.Column) error
+	vecEvalTime(input *chunk.Chunk, result *chunk.Column) error
+	vecEvalDuration(input *chunk.Chunk, result *chunk.Column) error
+	vecEvalJson(input *chunk.Chunk, result *chunk.Column) error
+}
+
+// vectorizedFunctionClass is an optional interface a functionClass can
+// implement to declare, independent of any particular call's arguments,
+// whether the builtins it produces ever provide a native vecEval*
+// implementation. This is the registry-level flag the vectorization
+// request asked for: it lets a caller check whether vectorizing a call to
+// funcName is worth doing before it has even built a ScalarFunction.
+type vectorizedFunctionClass interface {
+	vectorized() bool
+}
+
+// FunctionSupportsVectorization reports whether funcName's functionClass
+// declares a native vectorized implementation. Functions with no such
+// declaration (including every functionClass that doesn't implement
+// vectorizedFunctionClass) report false, meaning ScalarFunction.VecEval*
+// still works for them, just through the slower row-at-a-time fallback.
+func FunctionSupportsVectorization(funcName string) bool {
+	funcsMu.RLock()
+	fc, ok := funcs[funcName]
+	funcsMu.RUnlock()
+	if !ok {
+		return false
+	}
+	vfc, ok := fc.(vectorizedFunctionClass)
+	return ok && vfc.vectorized()
+}
+
+// baseVecBuiltinFunc gives a builtinFunc signature a default vecBuiltinFunc
+// implementation that reports no native support and errors on every
+// vecEval* call. A signature embeds this and overrides vectorized() plus
+// whichever vecEval* methods match its own eval kind, the same pattern
+// baseBuiltinFunc uses for the scalar eval* methods.
+type baseVecBuiltinFunc struct{}
+
+func (b *baseVecBuiltinFunc) vectorized() bool { return false }
+
+func (b *baseVecBuiltinFunc) vecEvalInt(input *chunk.Chunk, result *chunk.Column) error {
+	return errors.New("expression: no native vecEvalInt implementation")
+}
+
+func (b *baseVecBuiltinFunc) vecEvalReal(input *chunk.Chunk, result *chunk.Column) error {
+	return errors.New("expression: no native vecEvalReal implementation")
+}
+
+func (b *baseVecBuiltinFunc) vecEvalDecimal(input *chunk.Chunk, result *chunk.Column) error {
+	return errors.New("expression: no native vecEvalDecimal implementation")
+}
+
+func (b *baseVecBuiltinFunc) vecEvalString(input *chunk.Chunk, result *chunk.Column) error {
+	return errors.New("expression: no native vecEvalString implementation")
+}
+
+func (b *baseVecBuiltinFunc) vecEvalTime(input *chunk.Chunk, result *chunk.Column) error {
+	return errors.New("expression: no native vecEvalTime implementation")
+}
+
+func (b *baseVecBuiltinFunc) vecEvalDuration(input *chunk.Chunk, result *chunk.Column) error {
+	return errors.New("expression: no native vecEvalDuration implementation")
+}
+
+func (b *baseVecBuiltinFunc) vecEvalJson(input *chunk.Chunk, result *chunk.Column) error {
+	return errors.New("expression: no native vecEvalJson implementation")
+}
+
+// argFieldTypes returns the FieldType of each of args, in order. It is a
+// convenience for building a schema that happens to consist of exactly
+// these expressions (e.g. constructing a test chunk whose only columns are
+// a call's own arguments); it must NOT be used to decode a row out of an
+// arbitrary input chunk; see the comment on rowFieldTypes below for why.
+func argFieldTypes(args []Expression) []*types.FieldType {
+	fts := make([]*types.FieldType, len(args))
+	for i, arg := range args {
+		fts[i] = arg.GetType()
+	}
+	return fts
+}
+
+// rowFieldTypes returns the FieldType of every column in input, in schema
+// order, for use with chunk.Row.GetDatumRow. GetDatumRow must be called
+// against the full width of the row, not just the width of some
+// expression's own argument list: a *Column's Index addresses input's
+// schema directly (the same row-wide numbering the tree-walking Eval* path
+// already assumes), and for a predicate like `col5 < col9` that schema is
+// far wider than the 2 expressions referencing it. Decoding with anything
+// narrower panics with an out-of-range index the moment a Column.Index
+// reaches past the decoded row's length.
+func rowFieldTypes(input *chunk.Chunk) []*types.FieldType {
+	return input.FieldTypes()
+}
+
+// VecEvalInt evaluates sf over every row of input and writes the results into
+// result. It dispatches to the signature's native vecEvalInt when available,
+// and otherwise falls back to calling EvalInt once per row.
+func (sf *ScalarFunction) VecEvalInt(input *chunk.Chunk, result *chunk.Column) error {
+	if vf, ok := sf.Function.(vecBuiltinFunc); ok && vf.vectorized() {
+		return errors.Trace(vf.vecEvalInt(input, result))
+	}
+	return errors.Trace(vecEvalIntByRow(sf, input, result))
+}
+
+// VecEvalReal is the real-typed counterpart of VecEvalInt.
+func (sf *ScalarFunction) VecEvalReal(input *chunk.Chunk, result *chunk.Column) error {
+	if vf, ok := sf.Function.(vecBuiltinFunc); ok && vf.vectorized() {
+		return errors.Trace(vf.vecEvalReal(input, result))
+	}
+	return errors.Trace(vecEvalRealByRow(sf, input, result))
+}
+
+// VecEvalDecimal is the decimal-typed counterpart of VecEvalInt.
+func (sf *ScalarFunction) VecEvalDecimal(input *chunk.Chunk, result *chunk.Column) error {
+	if vf, ok := sf.Function.(vecBuiltinFunc); ok && vf.vectorized() {
+		return errors.Trace(vf.vecEvalDecimal(input, result))
+	}
+	return errors.Trace(vecEvalDecimalByRow(sf, input, result))
+}
+
+// VecEvalString is the string-typed counterpart of VecEvalInt.
+func (sf *ScalarFunction) VecEvalString(input *chunk.Chunk, result *chunk.Column) error {
+	if vf, ok := sf.Function.(vecBuiltinFunc); ok && vf.vectorized() {
+		return errors.Trace(vf.vecEvalString(input, result))
+	}
+	return errors.Trace(vecEvalStringByRow(sf, input, result))
+}
+
+// VecEvalTime is the time-typed counterpart of VecEvalInt.
+func (sf *ScalarFunction) VecEvalTime(input *chunk.Chunk, result *chunk.Column) error {
+	if vf, ok := sf.Function.(vecBuiltinFunc); ok && vf.vectorized() {
+		return errors.Trace(vf.vecEvalTime(input, result))
+	}
+	return errors.Trace(vecEvalTimeByRow(sf, input, result))
+}
+
+// VecEvalDuration is the duration-typed counterpart of VecEvalInt.
+func (sf *ScalarFunction) VecEvalDuration(input *chunk.Chunk, result *chunk.Column) error {
+	if vf, ok := sf.Function.(vecBuiltinFunc); ok && vf.vectorized() {
+		return errors.Trace(vf.vecEvalDuration(input, result))
+	}
+	return errors.Trace(vecEvalDurationByRow(sf, input, result))
+}
+
+// VecEvalJson is the JSON-typed counterpart of VecEvalInt.
+func (sf *ScalarFunction) VecEvalJson(input *chunk.Chunk, result *chunk.Column) error {
+	if vf, ok := sf.Function.(vecBuiltinFunc); ok && vf.vectorized() {
+		return errors.Trace(vf.vecEvalJson(input, result))
+	}
+	return errors.Trace(vecEvalJsonByRow(sf, input, result))
+}
+
+// vecEvalIntByRow is the generic fallback used by builtins that have not yet
+// been given a native vectorized implementation. It lets every ScalarFunction
+// be vectorized from day one, at the cost of per-row virtual dispatch.
+func vecEvalIntByRow(sf *ScalarFunction, input *chunk.Chunk, result *chunk.Column) error {
+	n := input.NumRows()
+	result.ResizeInt64(n, false)
+	i64s := result.Int64s()
+	sc := sf.GetCtx().GetSessionVars().StmtCtx
+	for i := 0; i < n; i++ {
+		v, isNull, err := sf.EvalInt(input.GetRow(i).GetDatumRow(rowFieldTypes(input)), sc)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		result.SetNull(i, isNull)
+		if !isNull {
+			i64s[i] = v
+		}
+	}
+	return nil
+}
+
+func vecEvalRealByRow(sf *ScalarFunction, input *chunk.Chunk, result *chunk.Column) error {
+	n := input.NumRows()
+	result.ResizeFloat64(n, false)
+	f64s := result.Float64s()
+	sc := sf.GetCtx().GetSessionVars().StmtCtx
+	for i := 0; i < n; i++ {
+		v, isNull, err := sf.EvalReal(input.GetRow(i).GetDatumRow(rowFieldTypes(input)), sc)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		result.SetNull(i, isNull)
+		if !isNull {
+			f64s[i] = v
+		}
+	}
+	return nil
+}
+
+func vecEvalDecimalByRow(sf *ScalarFunction, input *chunk.Chunk, result *chunk.Column) error {
+	n := input.NumRows()
+	result.ResizeDecimal(n, false)
+	decs := result.Decimals()
+	sc := sf.GetCtx().GetSessionVars().StmtCtx
+	for i := 0; i < n; i++ {
+		v, isNull, err := sf.EvalDecimal(input.GetRow(i).GetDatumRow(rowFieldTypes(input)), sc)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		result.SetNull(i, isNull)
+		if !isNull {
+			decs[i] = *v
+		}
+	}
+	return nil
+}
+
+func vecEvalStringByRow(sf *ScalarFunction, input *chunk.Chunk, result *chunk.Column) error {
+	n := input.NumRows()
+	result.ReserveString(n)
+	sc := sf.GetCtx().GetSessionVars().StmtCtx
+	for i := 0; i < n; i++ {
+		v, isNull, err := sf.EvalString(input.GetRow(i).GetDatumRow(rowFieldTypes(input)), sc)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if isNull {
+			result.AppendNull()
+			continue
+		}
+		result.AppendString(v)
+	}
+	return nil
+}
+
+func vecEvalTimeByRow(sf *ScalarFunction, input *chunk.Chunk, result *chunk.Column) error {
+	n := input.NumRows()
+	result.ResizeTime(n, false)
+	times := result.Times()
+	sc := sf.GetCtx().GetSessionVars().StmtCtx
+	for i := 0; i < n; i++ {
+		v, isNull, err := sf.EvalTime(input.GetRow(i).GetDatumRow(rowFieldTypes(input)), sc)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		result.SetNull(i, isNull)
+		if !isNull {
+			times[i] = v
+		}
+	}
+	return nil
+}
+
+func vecEvalDurationByRow(sf *ScalarFunction, input *chunk.Chunk, result *chunk.Column) error {
+	n := input.NumRows()
+	result.ResizeDuration(n, false)
+	durs := result.Durations()
+	sc := sf.GetCtx().GetSessionVars().StmtCtx
+	for i := 0; i < n; i++ {
+		v, isNull, err := sf.EvalDuration(input.GetRow(i).GetDatumRow(rowFieldTypes(input)), sc)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		result.SetNull(i, isNull)
+		if !isNull {
+			durs[i] = v
+		}
+	}
+	return nil
+}
+
+func vecEvalJsonByRow(sf *ScalarFunction, input *chunk.Chunk, result *chunk.Column) error {
+	n := input.NumRows()
+	result.ReserveJson(n)
+	sc := sf.GetCtx().GetSessionVars().StmtCtx
+	for i := 0; i < n; i++ {
+		v, isNull, err := sf.EvalJson(input.GetRow(i).GetDatumRow(rowFieldTypes(input)), sc)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if isNull {
+			result.AppendNull()
+			continue
+		}
+		result.AppendJson(v)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/mock"
+	"github.com/pingcap/tidb/model"
+	"github.com/pingcap/tidb/mysql"
+	"github.com/pingcap/tidb/util/chunk"
+	"github.com/pingcap/tidb/util/types"
+	"github.com/pingcap/tidb/util/types/json"
+)
+
+var _ = Suite(&testScalarFunctionVecSuite{})
+
+type testScalarFunctionVecSuite struct{}
+
+// TestFunctionSupportsVectorizationRegistry checks the functionClass-level
+// flag: json_match declares a native implementation, an ordinary builtin
+// (json_walk, which has none) does not, and an unknown name is reported the
+// same as "no native implementation" rather than panicking.
+func (s *testScalarFunctionVecSuite) TestFunctionSupportsVectorizationRegistry(c *C) {
+	c.Assert(FunctionSupportsVectorization(jsonMatchFuncName), IsTrue)
+	c.Assert(FunctionSupportsVectorization(jsonWalkFuncName), IsFalse)
+	c.Assert(FunctionSupportsVectorization("no_such_function"), IsFalse)
+}
+
+// TestJSONMatchVecEvalIntMatchesRowFallback builds a two-row chunk of
+// (json_doc, path_pattern) pairs and checks that JSON_MATCH's native
+// vecEvalInt agrees, row for row, with vecEvalIntByRow, the generic
+// fallback it bypasses.
+func (s *testScalarFunctionVecSuite) TestJSONMatchVecEvalIntMatchesRowFallback(c *C) {
+	ctx := mock.NewContext()
+	docJSON := json.CreateJSON(map[string]interface{}{"a": map[string]interface{}{"b": 1}})
+	doc := &Constant{Value: types.NewDatum(docJSON), RetType: types.NewFieldType(mysql.TypeJSON)}
+	pattern := &Constant{Value: types.NewStringDatum("$.a.b"), RetType: types.NewFieldType(mysql.TypeVarString)}
+
+	fc := &jsonMatchFunctionClass{baseFunctionClass{jsonMatchFuncName, 2, 2}}
+	bf, err := fc.getFunction([]Expression{doc, pattern}, ctx)
+	c.Assert(err, IsNil)
+	sig, ok := bf.(*builtinJSONMatchSig)
+	c.Assert(ok, IsTrue)
+	c.Assert(sig.vectorized(), IsTrue)
+
+	fts := argFieldTypes(sig.args)
+	input := chunk.NewChunkWithCapacity(fts, 2)
+	input.AppendJson(0, docJSON)
+	input.AppendString(1, "$.a.b")
+	input.AppendJson(0, docJSON)
+	input.AppendString(1, "$.x.y")
+
+	native := chunk.NewColumn(types.NewFieldType(mysql.TypeLonglong), input.NumRows())
+	c.Assert(sig.vecEvalInt(input, native), IsNil)
+
+	sf := &ScalarFunction{FuncName: model.NewCIStr(jsonMatchFuncName), Function: sig, RetType: sig.tp}
+	fallback := chunk.NewColumn(types.NewFieldType(mysql.TypeLonglong), input.NumRows())
+	c.Assert(vecEvalIntByRow(sf, input, fallback), IsNil)
+
+	c.Assert(native.Int64s(), DeepEquals, fallback.Int64s())
+}
+
+// TestVecEvalIntByRowUsesFullChunkSchema builds a three-column chunk where
+// JSON_MATCH's two arguments are Columns at index 1 and 2, not 0 and 1, the
+// same way a predicate like `json_match(col1, col2)` would be planned
+// against a wider input schema. vecEvalIntByRow must decode each row against
+// the chunk's full width: decoding against only the two argument types (as
+// argFieldTypes would) truncates the row to length 2, and Column{Index: 2}
+// then indexes past the end of it.
+func (s *testScalarFunctionVecSuite) TestVecEvalIntByRowUsesFullChunkSchema(c *C) {
+	ctx := mock.NewContext()
+	docJSON := json.CreateJSON(map[string]interface{}{"a": map[string]interface{}{"b": 1}})
+
+	intFt := types.NewFieldType(mysql.TypeLonglong)
+	jsonFt := types.NewFieldType(mysql.TypeJSON)
+	stringFt := types.NewFieldType(mysql.TypeVarString)
+	input := chunk.NewChunkWithCapacity([]*types.FieldType{intFt, jsonFt, stringFt}, 1)
+	input.AppendInt64(0, 42)
+	input.AppendJson(1, docJSON)
+	input.AppendString(2, "$.a.b")
+
+	docCol := &Column{Index: 1, RetType: jsonFt}
+	patternCol := &Column{Index: 2, RetType: stringFt}
+	fc := &jsonMatchFunctionClass{baseFunctionClass{jsonMatchFuncName, 2, 2}}
+	bf, err := fc.getFunction([]Expression{docCol, patternCol}, ctx)
+	c.Assert(err, IsNil)
+	sig, ok := bf.(*builtinJSONMatchSig)
+	c.Assert(ok, IsTrue)
+
+	sf := &ScalarFunction{FuncName: model.NewCIStr(jsonMatchFuncName), Function: sig, RetType: sig.tp}
+	result := chunk.NewColumn(types.NewFieldType(mysql.TypeLonglong), input.NumRows())
+	c.Assert(vecEvalIntByRow(sf, input, result), IsNil)
+	c.Assert(result.Int64s(), DeepEquals, []int64{1})
+}
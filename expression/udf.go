@@ -0,0 +1,317 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/pingcap/tidb/context"
+	"github.com/pingcap/tidb/mysql"
+	"github.com/pingcap/tidb/util/types"
+	"github.com/pingcap/tidb/util/types/json"
+)
+
+// FunctionSignature describes a user-registered function: its argument and
+// return types, whether it may be constant-folded, and the Go closures that
+// implement evaluation. Only the eval* closures relevant to how the
+// function is actually used need to be set; a nil closure for an eval kind
+// that's never requested is fine, the same tolerance builtinFunc signatures
+// already have for the eval kinds outside their declared TypeClass.
+type FunctionSignature struct {
+	Name string
+	// ArgTypes declares the TypeClass expected for each argument.
+	ArgTypes []types.TypeClass
+	// Variadic, when true, allows any number of trailing arguments of the
+	// last ArgTypes entry's class, the same shape functionClass exposes
+	// via its own min/max arg count for builtins like COALESCE.
+	Variadic bool
+	RetType  *types.FieldType
+	// Deterministic must be false for any signature whose result can
+	// differ across calls given identical arguments (wall-clock time,
+	// external state, randomness). FoldConstant must never bake a
+	// non-deterministic call into a cached plan; IsDeterministic is the
+	// hook it uses to check that.
+	Deterministic bool
+
+	EvalInt      func(ctx context.Context, args []types.Datum) (int64, bool, error)
+	EvalReal     func(ctx context.Context, args []types.Datum) (float64, bool, error)
+	EvalDecimal  func(ctx context.Context, args []types.Datum) (*types.MyDecimal, bool, error)
+	EvalString   func(ctx context.Context, args []types.Datum) (string, bool, error)
+	EvalTime     func(ctx context.Context, args []types.Datum) (types.Time, bool, error)
+	EvalDuration func(ctx context.Context, args []types.Datum) (types.Duration, bool, error)
+	EvalJson     func(ctx context.Context, args []types.Datum) (json.JSON, bool, error)
+}
+
+// funcsMu guards both the package-level funcs registry (previously read
+// unsynchronized by NewFunction, back when it could only be populated at
+// init time) and udfRegistry below. Query execution only ever reads funcs;
+// RegisterFunction is the sole writer, expected to run during startup or
+// embedder initialization rather than concurrently with live queries.
+var funcsMu sync.RWMutex
+
+// udfRegistry holds the FunctionSignature behind each registered UDF, keyed
+// by lower-cased name, so SHOW FUNCTION STATUS and similar introspection
+// can describe a UDF beyond what the functionClass/builtinFunc interfaces
+// expose.
+var udfRegistry = map[string]*FunctionSignature{}
+
+// RegisterFunction makes sig callable as NAME(...) the same as any builtin,
+// by installing a functionClass that wraps sig into the funcs registry
+// NewFunction already consults. It is the extension point for embedders who
+// need to add functions to the expression layer without forking the
+// builtins directory. RegisterFunction is safe to call concurrently with
+// itself, but must not race with query execution resolving funcs[name].
+func RegisterFunction(name string, sig *FunctionSignature) error {
+	if name == "" {
+		return errors.New("expression: RegisterFunction requires a non-empty name")
+	}
+	name = strings.ToLower(name)
+	if sig.RetType == nil {
+		return errors.Errorf("expression: %q: RetType is required", name)
+	}
+	funcsMu.Lock()
+	defer funcsMu.Unlock()
+	if _, ok := funcs[name]; ok {
+		return errors.Errorf("expression: function %q is already registered", name)
+	}
+	sig.Name = name
+	udfRegistry[name] = sig
+	funcs[name] = &udfFunctionClass{sig: sig}
+	return nil
+}
+
+// IsDeterministic reports whether sf wraps a user-registered function
+// declared non-deterministic, in which case FoldConstant must leave it
+// unevaluated. Builtins are unaffected and always read as deterministic
+// here; they carry their own deterministic/non-deterministic handling
+// (e.g. RAND, NOW) through the existing FoldConstant logic.
+func IsDeterministic(sf *ScalarFunction) bool {
+	sig, ok := sf.Function.(*builtinUDFSig)
+	if !ok {
+		return true
+	}
+	return sig.sig.Deterministic
+}
+
+// udfFunctionClass adapts a user-registered FunctionSignature to the
+// functionClass interface so it can sit in the funcs registry next to
+// built-in function classes.
+type udfFunctionClass struct {
+	sig *FunctionSignature
+}
+
+func (c *udfFunctionClass) getFunction(args []Expression, ctx context.Context) (builtinFunc, error) {
+	minArgs := len(c.sig.ArgTypes)
+	if c.sig.Variadic {
+		if minArgs == 0 {
+			return nil, errors.Errorf("expression: %s: a variadic signature needs at least one declared ArgTypes entry", c.sig.Name)
+		}
+		// The last ArgTypes entry is the class every trailing argument must
+		// satisfy, so the minimum call still needs everything up to and
+		// including it.
+		if len(args) < minArgs {
+			return nil, errors.Errorf("expression: %s takes at least %d argument(s), got %d", c.sig.Name, minArgs, len(args))
+		}
+	} else if len(args) != minArgs {
+		return nil, errors.Errorf("expression: %s takes %d argument(s), got %d", c.sig.Name, minArgs, len(args))
+	}
+	coerced, err := c.coerceArgs(args, ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	bf := newBaseBuiltinFunc(coerced, ctx)
+	bf.tp = c.sig.RetType
+	return &builtinUDFSig{bf, c.sig}, nil
+}
+
+// coerceArgs checks each argument's TypeClass against the signature's
+// declared ArgTypes, wrapping an argument in a CAST when it doesn't already
+// match so that e.g. passing a string literal where EvalInt is declared
+// still works. Arguments past the end of ArgTypes (the variadic tail) are
+// all checked/coerced against the last declared entry.
+func (c *udfFunctionClass) coerceArgs(args []Expression, ctx context.Context) ([]Expression, error) {
+	out := make([]Expression, len(args))
+	for i, arg := range args {
+		declIdx := i
+		if declIdx >= len(c.sig.ArgTypes) {
+			declIdx = len(c.sig.ArgTypes) - 1
+		}
+		want := c.sig.ArgTypes[declIdx]
+		if arg.GetTypeClass() == want {
+			out[i] = arg
+			continue
+		}
+		casted, err := buildCastFunction(arg, fieldTypeForClass(want), ctx)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		out[i] = casted
+	}
+	return out, nil
+}
+
+// fieldTypeForClass returns a representative FieldType for tc, used only to
+// drive the CAST that coerceArgs wraps a mismatched argument in.
+func fieldTypeForClass(tc types.TypeClass) *types.FieldType {
+	switch tc {
+	case types.ClassInt:
+		return types.NewFieldType(mysql.TypeLonglong)
+	case types.ClassReal:
+		return types.NewFieldType(mysql.TypeDouble)
+	case types.ClassDecimal:
+		return types.NewFieldType(mysql.TypeNewDecimal)
+	default:
+		return types.NewFieldType(mysql.TypeVarString)
+	}
+}
+
+// builtinUDFSig is the builtinFunc implementation backing every call to a
+// RegisterFunction-registered function. It dispatches each eval* method to
+// the matching closure on sig, the same way builtin*Sig types dispatch to
+// hand-written Go logic.
+type builtinUDFSig struct {
+	baseBuiltinFunc
+	sig *FunctionSignature
+}
+
+func (b *builtinUDFSig) evalArgs(row []types.Datum) ([]types.Datum, error) {
+	args := make([]types.Datum, len(b.args))
+	for i, arg := range b.args {
+		d, err := arg.Eval(row)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		args[i] = d
+	}
+	return args, nil
+}
+
+func (b *builtinUDFSig) evalInt(row []types.Datum) (int64, bool, error) {
+	if b.sig.EvalInt == nil {
+		return 0, false, errors.Errorf("expression: %s has no EvalInt implementation", b.sig.Name)
+	}
+	args, err := b.evalArgs(row)
+	if err != nil {
+		return 0, false, errors.Trace(err)
+	}
+	return b.sig.EvalInt(b.ctx, args)
+}
+
+func (b *builtinUDFSig) evalReal(row []types.Datum) (float64, bool, error) {
+	if b.sig.EvalReal == nil {
+		return 0, false, errors.Errorf("expression: %s has no EvalReal implementation", b.sig.Name)
+	}
+	args, err := b.evalArgs(row)
+	if err != nil {
+		return 0, false, errors.Trace(err)
+	}
+	return b.sig.EvalReal(b.ctx, args)
+}
+
+func (b *builtinUDFSig) evalDecimal(row []types.Datum) (*types.MyDecimal, bool, error) {
+	if b.sig.EvalDecimal == nil {
+		return nil, false, errors.Errorf("expression: %s has no EvalDecimal implementation", b.sig.Name)
+	}
+	args, err := b.evalArgs(row)
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	return b.sig.EvalDecimal(b.ctx, args)
+}
+
+func (b *builtinUDFSig) evalString(row []types.Datum) (string, bool, error) {
+	if b.sig.EvalString == nil {
+		return "", false, errors.Errorf("expression: %s has no EvalString implementation", b.sig.Name)
+	}
+	args, err := b.evalArgs(row)
+	if err != nil {
+		return "", false, errors.Trace(err)
+	}
+	return b.sig.EvalString(b.ctx, args)
+}
+
+func (b *builtinUDFSig) evalTime(row []types.Datum) (types.Time, bool, error) {
+	if b.sig.EvalTime == nil {
+		return types.Time{}, false, errors.Errorf("expression: %s has no EvalTime implementation", b.sig.Name)
+	}
+	args, err := b.evalArgs(row)
+	if err != nil {
+		return types.Time{}, false, errors.Trace(err)
+	}
+	return b.sig.EvalTime(b.ctx, args)
+}
+
+func (b *builtinUDFSig) evalDuration(row []types.Datum) (types.Duration, bool, error) {
+	if b.sig.EvalDuration == nil {
+		return types.Duration{}, false, errors.Errorf("expression: %s has no EvalDuration implementation", b.sig.Name)
+	}
+	args, err := b.evalArgs(row)
+	if err != nil {
+		return types.Duration{}, false, errors.Trace(err)
+	}
+	return b.sig.EvalDuration(b.ctx, args)
+}
+
+func (b *builtinUDFSig) evalJson(row []types.Datum) (json.JSON, bool, error) {
+	if b.sig.EvalJson == nil {
+		return json.JSON{}, false, errors.Errorf("expression: %s has no EvalJson implementation", b.sig.Name)
+	}
+	args, err := b.evalArgs(row)
+	if err != nil {
+		return json.JSON{}, false, errors.Trace(err)
+	}
+	return b.sig.EvalJson(b.ctx, args)
+}
+
+func (b *builtinUDFSig) equal(f builtinFunc) bool {
+	other, ok := f.(*builtinUDFSig)
+	if !ok || other.sig != b.sig {
+		return false
+	}
+	if len(b.args) != len(other.args) {
+		return false
+	}
+	for i := range b.args {
+		if !b.args[i].Equal(other.args[i], b.ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// FunctionInfo is the read-only view of a registered UDF this package
+// exposes for introspection.
+type FunctionInfo struct {
+	Name          string
+	Deterministic bool
+}
+
+// RegisteredFunctions returns one FunctionInfo per UDF registered through
+// RegisterFunction. It is the extension point a SHOW FUNCTION STATUS
+// handler would call into to list UDFs alongside builtins; the executor
+// package that implements that statement is outside this series (this
+// tree has no executor package at all), so SHOW FUNCTION STATUS does not
+// actually render UDFs yet. Wiring that handler to call this is a
+// follow-up, not done here.
+func RegisteredFunctions() []FunctionInfo {
+	funcsMu.RLock()
+	defer funcsMu.RUnlock()
+	infos := make([]FunctionInfo, 0, len(udfRegistry))
+	for _, sig := range udfRegistry {
+		infos = append(infos, FunctionInfo{Name: sig.Name, Deterministic: sig.Deterministic})
+	}
+	return infos
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/context"
+	"github.com/pingcap/tidb/mock"
+	"github.com/pingcap/tidb/mysql"
+	"github.com/pingcap/tidb/util/types"
+)
+
+var _ = Suite(&testUDFSuite{})
+
+type testUDFSuite struct{}
+
+func (s *testUDFSuite) TestNonDeterministicUDFIsNotFolded(c *C) {
+	ctx := mock.NewContext()
+	calls := 0
+	err := RegisterFunction("test_nondeterministic_udf", &FunctionSignature{
+		ArgTypes:      []types.TypeClass{types.ClassInt},
+		RetType:       types.NewFieldType(mysql.TypeLonglong),
+		Deterministic: false,
+		EvalInt: func(_ context.Context, args []types.Datum) (int64, bool, error) {
+			calls++
+			return args[0].GetInt64() + int64(calls), false, nil
+		},
+	})
+	c.Assert(err, IsNil)
+
+	arg := &Constant{Value: types.NewIntDatum(1), RetType: types.NewFieldType(mysql.TypeLonglong)}
+	f, err := NewFunction(ctx, "test_nondeterministic_udf", types.NewFieldType(mysql.TypeLonglong), arg)
+	c.Assert(err, IsNil)
+
+	_, isScalar := f.(*ScalarFunction)
+	c.Assert(isScalar, IsTrue, Commentf("a non-deterministic UDF call with constant args must not be folded into a *Constant"))
+}
+
+func (s *testUDFSuite) TestDeterministicUDFWithConstantArgsIsFolded(c *C) {
+	ctx := mock.NewContext()
+	err := RegisterFunction("test_deterministic_udf", &FunctionSignature{
+		ArgTypes:      []types.TypeClass{types.ClassInt},
+		RetType:       types.NewFieldType(mysql.TypeLonglong),
+		Deterministic: true,
+		EvalInt: func(_ context.Context, args []types.Datum) (int64, bool, error) {
+			return args[0].GetInt64() * 2, false, nil
+		},
+	})
+	c.Assert(err, IsNil)
+
+	arg := &Constant{Value: types.NewIntDatum(21), RetType: types.NewFieldType(mysql.TypeLonglong)}
+	f, err := NewFunction(ctx, "test_deterministic_udf", types.NewFieldType(mysql.TypeLonglong), arg)
+	c.Assert(err, IsNil)
+
+	folded, ok := f.(*Constant)
+	c.Assert(ok, IsTrue)
+	c.Assert(folded.Value.GetInt64(), Equals, int64(42))
+}
+
+func (s *testUDFSuite) TestVariadicRequiresMinimumArity(c *C) {
+	ctx := mock.NewContext()
+	err := RegisterFunction("test_variadic_udf", &FunctionSignature{
+		ArgTypes: []types.TypeClass{types.ClassInt},
+		Variadic: true,
+		RetType:  types.NewFieldType(mysql.TypeLonglong),
+	})
+	c.Assert(err, IsNil)
+
+	_, err = NewFunction(ctx, "test_variadic_udf", types.NewFieldType(mysql.TypeLonglong))
+	c.Assert(err, NotNil)
+}